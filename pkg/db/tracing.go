@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/opentracing/opentracing-go"
+)
+
+// sqlRunner is satisfied by both *sql.DB and *sql.Tx.
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tracingRunner wraps a squirrel base runner so that every *Context query
+// executed through it creates a child span of whatever span is active on
+// the context, tagged with the rendered SQL.
+type tracingRunner struct {
+	db sqlRunner
+}
+
+// WrapWithTracing adapts db (typically a *sql.DB or *sql.Tx) into a
+// squirrel.BaseRunner whose *Context queries are reported as child spans of
+// the span active on the passed context. Plain, non-Context Exec/Query
+// calls are passed straight through.
+func WrapWithTracing(db sqlRunner) squirrel.BaseRunner {
+	return &tracingRunner{db: db}
+}
+
+func (r *tracingRunner) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return r.db.Exec(query, args...)
+}
+
+func (r *tracingRunner) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db.Query(query, args...)
+}
+
+func (r *tracingRunner) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sql.Exec")
+	defer span.Finish()
+	span.SetTag("db.statement", query)
+	return r.db.ExecContext(ctx, query, args...)
+}
+
+func (r *tracingRunner) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sql.Query")
+	defer span.Finish()
+	span.SetTag("db.statement", query)
+	return r.db.QueryContext(ctx, query, args...)
+}
+
+func (r *tracingRunner) QueryRowContext(ctx context.Context, query string, args ...interface{}) squirrel.RowScanner {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "sql.QueryRow")
+	defer span.Finish()
+	span.SetTag("db.statement", query)
+	return r.db.QueryRowContext(ctx, query, args...)
+}
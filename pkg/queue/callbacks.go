@@ -0,0 +1,11 @@
+package queue
+
+import "context"
+
+// TaskStateChangeFunc is invoked whenever a task transitions between
+// statuses, e.g. pending -> running, or running -> cancelled.
+type TaskStateChangeFunc func(ctx context.Context, from, to TaskStatus, task Task)
+
+// ExecutionCompleteFunc is invoked once every task under an execution has
+// reached a terminal status.
+type ExecutionCompleteFunc func(ctx context.Context, execution Execution)
@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// ExecutionStatus is the derived, aggregate status of an Execution, computed
+// from the status of its child tasks.
+type ExecutionStatus string
+
+const (
+	// ExecutionInProgress means at least one child task has not yet reached
+	// a terminal status.
+	ExecutionInProgress ExecutionStatus = "in_progress"
+	// ExecutionSucceeded means every child task succeeded.
+	ExecutionSucceeded ExecutionStatus = "succeeded"
+	// ExecutionFailed means every child task is terminal and at least one failed.
+	ExecutionFailed ExecutionStatus = "failed"
+	// ExecutionStopped means every child task is terminal and at least one
+	// was cancelled, but none failed.
+	ExecutionStopped ExecutionStatus = "stopped"
+)
+
+// ExecutionCounters is the rollup of child task statuses for one execution.
+type ExecutionCounters struct {
+	Total      int `json:"total"`
+	Succeeded  int `json:"succeeded"`
+	Failed     int `json:"failed"`
+	InProgress int `json:"inProgress"`
+	Stopped    int `json:"stopped"`
+}
+
+// Status derives the aggregate ExecutionStatus from the counters. It is the
+// single place this derivation happens so that the rollup task and any
+// reader agree on what "done" means.
+func (c ExecutionCounters) Status() ExecutionStatus {
+	switch {
+	case c.InProgress > 0:
+		return ExecutionInProgress
+	case c.Failed > 0:
+		return ExecutionFailed
+	case c.Stopped > 0:
+		return ExecutionStopped
+	default:
+		return ExecutionSucceeded
+	}
+}
+
+// Execution groups every task spawned from one logical trigger - one cron
+// firing, or one manually-requested run - so they can be stopped, listed and
+// reported on together.
+type Execution struct {
+	ID         string            `json:"id"`
+	ScheduleID string            `json:"scheduleId"`
+	PolicyID   string            `json:"policyId"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Counters   ExecutionCounters `json:"counters"`
+}
+
+// Status is a convenience accessor for Counters.Status().
+func (e Execution) Status() ExecutionStatus {
+	return e.Counters.Status()
+}
+
+// ExecutionManager is the read/control surface for executions: listing them,
+// fetching one, and stopping or failing one out from under its tasks.
+type ExecutionManager interface {
+	Get(ctx context.Context, executionID string) (Execution, error)
+	List(ctx context.Context, policyID string) ([]Execution, error)
+	StopAndWait(ctx context.Context, executionID string, timeout time.Duration) error
+	MarkError(ctx context.Context, executionID string, msg string) error
+}
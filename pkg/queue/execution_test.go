@@ -0,0 +1,31 @@
+package queue
+
+import "testing"
+
+// TestExecutionCounters_Status guards the precedence ExecutionCounters.Status
+// applies when more than one condition holds: in-progress always wins (the
+// execution isn't done yet), then failed, then stopped, with succeeded as
+// the default once nothing else qualifies.
+func TestExecutionCounters_Status(t *testing.T) {
+	tests := []struct {
+		name string
+		c    ExecutionCounters
+		want ExecutionStatus
+	}{
+		{"all succeeded", ExecutionCounters{Total: 3, Succeeded: 3}, ExecutionSucceeded},
+		{"zero counters", ExecutionCounters{}, ExecutionSucceeded},
+		{"in progress", ExecutionCounters{Total: 2, InProgress: 1}, ExecutionInProgress},
+		{"failed", ExecutionCounters{Total: 2, Failed: 1, Succeeded: 1}, ExecutionFailed},
+		{"stopped", ExecutionCounters{Total: 2, Stopped: 1, Succeeded: 1}, ExecutionStopped},
+		{"in progress beats failed", ExecutionCounters{InProgress: 1, Failed: 1}, ExecutionInProgress},
+		{"failed beats stopped", ExecutionCounters{Failed: 1, Stopped: 1}, ExecutionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.Status(); got != tt.want {
+				t.Errorf("Status() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
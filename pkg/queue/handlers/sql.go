@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiamo/go-base/v2/pkg/queue"
+)
+
+// SQLExecTaskSpec is the task spec embedded by handlers whose work is a
+// single SQL statement executed against the queue's database. The handler
+// from NewSQLTaskHandler runs SQL with no args, so SQL given to it must
+// already have its parameters bound in. Specs that embed it with
+// placeholder SQL (the postgres retention spec does) carry their args in
+// their own fields and are run by their own handler.
+type SQLExecTaskSpec struct {
+	SQL string `json:"sql"`
+}
+
+// sqlTaskHandler runs the SQL statement carried in a SQLExecTaskSpec. The
+// number of affected rows is not reported.
+type sqlTaskHandler struct {
+	name string
+	db   *sql.DB
+}
+
+// NewSQLTaskHandler creates a TaskHandler that executes the `sql` field of
+// the task's spec against db. name is only used as the prefix of returned
+// error messages and does not need to be unique.
+func NewSQLTaskHandler(name string, db *sql.DB) queue.TaskHandler {
+	return &sqlTaskHandler{name: name, db: db}
+}
+
+func (h *sqlTaskHandler) Handle(ctx context.Context, task queue.Task) (err error) {
+	var spec SQLExecTaskSpec
+	if err = json.Unmarshal(task.Spec, &spec); err != nil {
+		return fmt.Errorf("%s: can not unmarshal task spec: %w", h.name, err)
+	}
+
+	_, err = h.db.ExecContext(ctx, spec.SQL)
+	if err != nil {
+		return fmt.Errorf("%s: can not execute task sql: %w", h.name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/contiamo/go-base/v2/pkg/queue"
+)
+
+// outbox event kinds, stored in task_events.kind and used by
+// callbackDispatchHandler to pick which registry to dispatch a row to.
+const (
+	taskStateChangeEvent    string = "task_state_change"
+	executionCompleteEvent  string = "execution_complete"
+	retentionCompletedEvent string = "retention_completed"
+)
+
+// RetentionCompleted is the payload emitted once a (non-dry-run) retention
+// task finishes, so downstream systems can react without polling `schedules`.
+type RetentionCompleted struct {
+	QueueName   string           `json:"queueName"`
+	TaskType    queue.TaskType   `json:"taskType"`
+	Status      queue.TaskStatus `json:"status"`
+	RowsDeleted int64            `json:"rowsDeleted"`
+}
+
+// RetentionCompletedFunc is invoked once per finished retention task.
+type RetentionCompletedFunc func(ctx context.Context, event RetentionCompleted)
+
+var (
+	callbacksMu                 sync.Mutex
+	taskStateChangeCallbacks    []queue.TaskStateChangeFunc
+	executionCompleteCallbacks  []queue.ExecutionCompleteFunc
+	retentionCompletedCallbacks []RetentionCompletedFunc
+)
+
+// OnTaskStateChange registers fn to be called, by the callback-dispatch
+// maintenance task, whenever a task transitions between statuses.
+func OnTaskStateChange(fn queue.TaskStateChangeFunc) {
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	taskStateChangeCallbacks = append(taskStateChangeCallbacks, fn)
+}
+
+// OnExecutionComplete registers fn to be called, by the callback-dispatch
+// maintenance task, for execution complete events. In this package only
+// Scheduler.MarkError enqueues such an event; an execution whose tasks all
+// reach a terminal status through Finish or Stop does not fire it.
+func OnExecutionComplete(fn queue.ExecutionCompleteFunc) {
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	executionCompleteCallbacks = append(executionCompleteCallbacks, fn)
+}
+
+// OnRetentionCompleted registers fn to be called, by the callback-dispatch
+// maintenance task, whenever a retention task finishes deleting rows.
+func OnRetentionCompleted(fn RetentionCompletedFunc) {
+	callbacksMu.Lock()
+	defer callbacksMu.Unlock()
+	retentionCompletedCallbacks = append(retentionCompletedCallbacks, fn)
+}
+
+// enqueueEvent inserts an outbox row for kind/payload using tx, so that it
+// commits atomically with whatever state write caused it.
+func enqueueEvent(ctx context.Context, tx *sql.Tx, kind string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("can not marshal %s event payload: %w", kind, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (kind, payload, created_at) VALUES ($1, $2, $3)", TaskEventsTable),
+		kind, payloadBytes, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("can not enqueue %s event: %w", kind, err)
+	}
+
+	return nil
+}
+
+// CallbackDispatchTask is the maintenance kind that drains TaskEventsTable
+// and invokes whichever Go callbacks are registered for each event's kind.
+const CallbackDispatchTask MaintenanceKind = "callback-dispatch"
+
+func init() {
+	RegisterMaintenanceKind(CallbackDispatchTask, MaintenanceKindRegistration{
+		NewHandler: NewCallbackDispatchHandler,
+		// callbacks should fire promptly, so dispatch every minute rather
+		// than distributing across the hour like a cleanup job would.
+		Distribute: func() string { return "* * * * *" },
+	})
+}
+
+type callbackDispatchTaskSpec struct{}
+
+func (callbackDispatchTaskSpec) Kind() MaintenanceKind             { return CallbackDispatchTask }
+func (callbackDispatchTaskSpec) IdentityFields() map[string]string { return nil }
+
+// AssertCallbackDispatchSchedule registers the single, recurring schedule
+// that drains the callback outbox. It is idempotent.
+func AssertCallbackDispatchSchedule(ctx context.Context, db *sql.DB) error {
+	return AssertMaintenanceSchedule(ctx, db, callbackDispatchTaskSpec{})
+}
+
+type callbackDispatchHandler struct {
+	db *sql.DB
+}
+
+// NewCallbackDispatchHandler creates the queue.TaskHandler backing CallbackDispatchTask.
+func NewCallbackDispatchHandler(db *sql.DB) queue.TaskHandler {
+	return &callbackDispatchHandler{db: db}
+}
+
+// Handle locks its pending rows with FOR UPDATE SKIP LOCKED before
+// dispatching them, so that an overlapping run - the next minute's firing,
+// say, while this one is still draining a backlog or waiting on a slow
+// callback - skips them instead of dispatching the same event twice. Rows
+// are only marked dispatched, and the whole claim committed, once every
+// event in the batch has been successfully dispatched; a failure partway
+// through rolls the claim back so the batch is retried next run.
+func (h *callbackDispatchHandler) Handle(ctx context.Context, task queue.Task) (err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not start transaction for dispatching callback events: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id, kind, payload FROM %s WHERE dispatched_at IS NULL ORDER BY id FOR UPDATE SKIP LOCKED", TaskEventsTable,
+	))
+	if err != nil {
+		return fmt.Errorf("can not list pending callback events: %w", err)
+	}
+
+	type pendingEvent struct {
+		id      int64
+		kind    string
+		payload []byte
+	}
+	var pending []pendingEvent
+	for rows.Next() {
+		var e pendingEvent
+		if err = rows.Scan(&e.id, &e.kind, &e.payload); err != nil {
+			rows.Close()
+			return fmt.Errorf("can not scan callback event: %w", err)
+		}
+		pending = append(pending, e)
+	}
+	closeErr := rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	for _, e := range pending {
+		if err = dispatchEvent(ctx, e.kind, e.payload); err != nil {
+			return fmt.Errorf("can not dispatch %s event %d: %w", e.kind, e.id, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("UPDATE %s SET dispatched_at = $1 WHERE id = $2", TaskEventsTable),
+			time.Now(), e.id,
+		)
+		if err != nil {
+			return fmt.Errorf("can not mark callback event %d dispatched: %w", e.id, err)
+		}
+	}
+
+	return nil
+}
+
+// dispatchEvent only holds callbacksMu long enough to snapshot the registry
+// for kind; the callbacks themselves run outside the lock. Holding the lock
+// across them would let a callback that registers another callback (a
+// reasonable thing for a downstream system to do) deadlock against
+// OnTaskStateChange/OnExecutionComplete/OnRetentionCompleted, and would block
+// all registration for as long as the slowest callback (e.g. a webhook call)
+// takes to run.
+func dispatchEvent(ctx context.Context, kind string, payload []byte) error {
+	switch kind {
+	case taskStateChangeEvent:
+		var e struct {
+			From, To queue.TaskStatus
+			Task     queue.Task
+		}
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return err
+		}
+		callbacksMu.Lock()
+		fns := append([]queue.TaskStateChangeFunc(nil), taskStateChangeCallbacks...)
+		callbacksMu.Unlock()
+		for _, fn := range fns {
+			fn(ctx, e.From, e.To, e.Task)
+		}
+
+	case executionCompleteEvent:
+		var execution queue.Execution
+		if err := json.Unmarshal(payload, &execution); err != nil {
+			return err
+		}
+		callbacksMu.Lock()
+		fns := append([]queue.ExecutionCompleteFunc(nil), executionCompleteCallbacks...)
+		callbacksMu.Unlock()
+		for _, fn := range fns {
+			fn(ctx, execution)
+		}
+
+	case retentionCompletedEvent:
+		var event RetentionCompleted
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		callbacksMu.Lock()
+		fns := append([]RetentionCompletedFunc(nil), retentionCompletedCallbacks...)
+		callbacksMu.Unlock()
+		for _, fn := range fns {
+			fn(ctx, event)
+		}
+
+	default:
+		return fmt.Errorf("unknown callback event kind %q", kind)
+	}
+
+	return nil
+}
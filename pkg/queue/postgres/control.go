@@ -0,0 +1,348 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	cdb "github.com/contiamo/go-base/v2/pkg/db"
+	"github.com/contiamo/go-base/v2/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+)
+
+// pollInterval is how often StopAndWait re-checks task status while waiting
+// for a cancelled execution to actually wind down.
+const pollInterval = 200 * time.Millisecond
+
+// Stop requests cancellation of a single running task: the task is marked
+// `stopping` in postgres and, if this Scheduler claimed it, its handler
+// context is cancelled immediately. Nothing in this package watches for
+// `stopping`, so a handler running under another Scheduler or process is
+// not cancelled by Stop. A task that is still `pending` is moved straight to
+// `cancelled` since no handler is running yet to signal.
+func (s *Scheduler) Stop(ctx context.Context, taskID string) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Scheduler.Stop")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("task.id", taskID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not start transaction for stopping task %q: %w", taskID, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = s.transitionTasks(ctx, tx, queue.StatusPending, queue.StatusCancelled,
+		squirrel.Eq{"id": taskID, "status": queue.StatusPending},
+		map[string]interface{}{"finished_at": time.Now()},
+	)
+	if err != nil {
+		return fmt.Errorf("can not cancel pending task %q: %w", taskID, err)
+	}
+
+	_, err = s.transitionTasks(ctx, tx, queue.StatusRunning, queue.StatusStopping,
+		squirrel.Eq{"id": taskID, "status": queue.StatusRunning},
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("can not mark running task %q stopping: %w", taskID, err)
+	}
+
+	s.mu.Lock()
+	t, ok := s.running[taskID]
+	s.mu.Unlock()
+	if ok {
+		t.cancel()
+	}
+
+	return nil
+}
+
+// Finish records the terminal outcome of a task this process claimed and
+// ran via Claim, moving it from running/stopping to status and releasing
+// its tracked cancel func. The caller driving a claimed task's handler must
+// call Finish exactly once Handle returns - it is the other half of Claim's
+// tracking: Claim registers the cancel func Stop signals, and Finish is what
+// clears it and finally moves a stopped task out of `stopping` into the
+// terminal `cancelled` status that StopAndWait is waiting to see.
+func (s *Scheduler) Finish(ctx context.Context, taskID string, status queue.TaskStatus, errMsg string) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Scheduler.Finish")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("task.id", taskID)
+	span.SetTag("task.status", string(status))
+
+	if !status.Terminal() {
+		return fmt.Errorf("can not finish task %q with non-terminal status %q", taskID, status)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not start transaction for finishing task %q: %w", taskID, err)
+	}
+	// committed explicitly below, once the status update has succeeded, so
+	// that untrack only ever runs once the terminal status is durable; this
+	// defer only ever fires the rollback half, on an earlier error.
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	extra := map[string]interface{}{"finished_at": time.Now()}
+	if errMsg != "" {
+		extra["error"] = errMsg
+	}
+
+	_, err = s.transitionTasks(ctx, tx, "", status,
+		squirrel.Eq{"id": taskID, "status": []queue.TaskStatus{queue.StatusRunning, queue.StatusStopping}},
+		extra,
+	)
+	if err != nil {
+		return fmt.Errorf("can not finish task %q: %w", taskID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can not commit finishing task %q: %w", taskID, err)
+	}
+
+	s.untrack(taskID)
+
+	return nil
+}
+
+// transitionTasks updates every task matched by where to status `to`,
+// applying any extra column sets, and enqueues a task_state_change callback
+// event for each row affected, in the same tx as the update. from is
+// recorded on the event as a best-effort label: when where can match tasks
+// in more than one prior status (as MarkError's can), it is the caller's
+// description of the transition rather than a per-row guarantee.
+func (s *Scheduler) transitionTasks(ctx context.Context, tx *sql.Tx, from, to queue.TaskStatus, where squirrel.Sqlizer, extra map[string]interface{}) ([]queue.Task, error) {
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(tx))
+
+	update := builder.Update(TasksTable).Set("status", to).Where(where)
+	for column, value := range extra {
+		update = update.Set(column, value)
+	}
+
+	rows, err := update.
+		Suffix("RETURNING id, queue, type, spec, execution_id, status, retry_count, created_at").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []queue.Task
+	for rows.Next() {
+		var t queue.Task
+		if err = rows.Scan(&t.ID, &t.Queue, &t.Type, &t.Spec, &t.ExecutionID, &t.Status, &t.RetryCount, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	closeErr := rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	for _, t := range tasks {
+		event := struct {
+			From, To queue.TaskStatus
+			Task     queue.Task
+		}{from, to, t}
+		if err = enqueueEvent(ctx, tx, taskStateChangeEvent, event); err != nil {
+			return nil, err
+		}
+	}
+
+	return tasks, nil
+}
+
+// StopAndWait stops every task belonging to executionID and blocks until all
+// of them have reached a terminal status or timeout elapses, whichever comes
+// first. It returns nil once every task is terminal, or the context/timeout
+// error if the deadline is reached first - callers can retry StopAndWait
+// with a fresh timeout to keep waiting.
+func (s *Scheduler) StopAndWait(ctx context.Context, executionID string, timeout time.Duration) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Scheduler.StopAndWait")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("execution.id", executionID)
+
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(s.db))
+
+	taskIDs, err := s.stoppableTaskIDs(ctx, builder, executionID)
+	if err != nil {
+		return fmt.Errorf("can not list tasks for execution %q: %w", executionID, err)
+	}
+
+	for _, taskID := range taskIDs {
+		if err = s.Stop(ctx, taskID); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		done, err := s.executionIsTerminal(ctx, builder, executionID)
+		if err != nil {
+			return fmt.Errorf("can not check execution %q status: %w", executionID, err)
+		}
+		if done {
+			span.SetTag("stopped", true)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for execution %q to stop: %w", executionID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// stoppableTaskIDs returns the IDs of tasks under executionID that have not
+// yet reached a terminal status.
+func (s *Scheduler) stoppableTaskIDs(ctx context.Context, builder squirrel.StatementBuilderType, executionID string) (ids []string, err error) {
+	rows, err := builder.Select("id").
+		From(TasksTable).
+		Where(squirrel.Eq{"execution_id": executionID}).
+		Where(squirrel.NotEq{"status": []queue.TaskStatus{queue.StatusSucceeded, queue.StatusFailed, queue.StatusCancelled}}).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// executionIsTerminal reports whether every task under executionID has
+// reached a terminal status.
+func (s *Scheduler) executionIsTerminal(ctx context.Context, builder squirrel.StatementBuilderType, executionID string) (bool, error) {
+	var remaining int
+	err := builder.Select("count(*)").
+		From(TasksTable).
+		Where(squirrel.Eq{"execution_id": executionID}).
+		Where(squirrel.NotEq{"status": []queue.TaskStatus{queue.StatusSucceeded, queue.StatusFailed, queue.StatusCancelled}}).
+		ScanContext(ctx, &remaining)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	return remaining == 0, nil
+}
+
+// MarkError atomically finalizes an execution as failed, moving every
+// non-terminal task under it straight to `failed` with msg recorded as the
+// error. This is used to close out an execution that could only be
+// partially created, e.g. because scheduling its tasks failed halfway
+// through - without it, such an execution would be stuck `running` forever
+// with no handler left to report its own failure. When it moved at least one
+// task to `failed`, it also enqueues an OnExecutionComplete callback event
+// for the execution; an execution with no non-terminal task left gets no
+// event.
+func (s *Scheduler) MarkError(ctx context.Context, executionID string, msg string) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Scheduler.MarkError")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("execution.id", executionID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not start transaction for marking execution %q errored: %w", executionID, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	failed, err := s.transitionTasks(ctx, tx, "", queue.StatusFailed,
+		squirrel.And{
+			squirrel.Eq{"execution_id": executionID},
+			squirrel.NotEq{"status": []queue.TaskStatus{queue.StatusSucceeded, queue.StatusFailed, queue.StatusCancelled}},
+		},
+		map[string]interface{}{"error": msg, "finished_at": time.Now()},
+	)
+	if err != nil {
+		return fmt.Errorf("can not mark execution %q as errored: %w", executionID, err)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	counters, err := s.executionCounters(ctx, tx, executionID)
+	if err != nil {
+		return fmt.Errorf("can not compute counters for execution %q: %w", executionID, err)
+	}
+
+	execution := queue.Execution{ID: executionID, Counters: counters}
+	if err = enqueueEvent(ctx, tx, executionCompleteEvent, execution); err != nil {
+		return fmt.Errorf("can not enqueue execution complete event for %q: %w", executionID, err)
+	}
+
+	return nil
+}
+
+// executionCounters computes the full status breakdown for every task under
+// executionID, scoped to tx so it reflects this same transaction's writes.
+// MarkError uses it instead of counting only the tasks it just transitioned,
+// since an execution handed to MarkError may already have succeeded,
+// cancelled or previously-failed tasks from before the error that triggered
+// it - the whole point of MarkError being callable on a partially-created
+// execution.
+func (s *Scheduler) executionCounters(ctx context.Context, tx *sql.Tx, executionID string) (queue.ExecutionCounters, error) {
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(tx))
+
+	var c queue.ExecutionCounters
+	err := builder.Select(
+		"count(*)",
+		fmt.Sprintf("count(*) FILTER (WHERE status = '%s')", queue.StatusSucceeded),
+		fmt.Sprintf("count(*) FILTER (WHERE status = '%s')", queue.StatusFailed),
+		fmt.Sprintf("count(*) FILTER (WHERE status IN ('%s', '%s', '%s'))", queue.StatusPending, queue.StatusRunning, queue.StatusStopping),
+		fmt.Sprintf("count(*) FILTER (WHERE status = '%s')", queue.StatusCancelled),
+	).
+		From(TasksTable).
+		Where(squirrel.Eq{"execution_id": executionID}).
+		ScanContext(ctx, &c.Total, &c.Succeeded, &c.Failed, &c.InProgress, &c.Stopped)
+	if err != nil {
+		return queue.ExecutionCounters{}, err
+	}
+
+	return c, nil
+}
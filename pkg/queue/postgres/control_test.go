@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/contiamo/go-base/v2/pkg/queue"
+)
+
+// TestScheduler_TrackContextUntrack guards the track/Context/untrack
+// bookkeeping Claim and Finish rely on to let Stop cancel an in-process
+// handler: a claimed task's context must be retrievable until it is
+// untracked, and absent afterwards.
+func TestScheduler_TrackContextUntrack(t *testing.T) {
+	s := NewScheduler(nil)
+
+	if _, ok := s.Context("task-1"); ok {
+		t.Fatalf("Context: expected no context before track")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.track("task-1", ctx, cancel)
+
+	got, ok := s.Context("task-1")
+	if !ok || got != ctx {
+		t.Fatalf("Context: expected to retrieve the tracked context")
+	}
+
+	s.untrack("task-1")
+
+	if _, ok := s.Context("task-1"); ok {
+		t.Fatalf("Context: expected no context after untrack")
+	}
+}
+
+// TestScheduler_Finish_RejectsNonTerminalStatus ensures Finish is only used
+// to record a claimed task's terminal outcome, not as a general-purpose
+// status setter.
+func TestScheduler_Finish_RejectsNonTerminalStatus(t *testing.T) {
+	s := NewScheduler(nil)
+
+	err := s.Finish(context.Background(), "task-1", queue.StatusRunning, "")
+	if err == nil {
+		t.Fatalf("Finish: expected an error for non-terminal status %q", queue.StatusRunning)
+	}
+}
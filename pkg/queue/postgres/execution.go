@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	cdb "github.com/contiamo/go-base/v2/pkg/db"
+	"github.com/contiamo/go-base/v2/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+)
+
+// executionManager is the postgres-backed queue.ExecutionManager. Stop
+// control is delegated to an embedded Scheduler, which looks up an
+// execution's tasks and stops each one by task ID. The embedded Scheduler is
+// a new instance that has claimed nothing itself, so it only marks tasks in
+// postgres and cancels no in-process handler.
+type executionManager struct {
+	*Scheduler
+	db *sql.DB
+}
+
+// NewExecutionManager creates a queue.ExecutionManager backed by db.
+func NewExecutionManager(db *sql.DB) queue.ExecutionManager {
+	return &executionManager{Scheduler: NewScheduler(db), db: db}
+}
+
+// CreateExecution inserts a new execution row for a task spawned from
+// scheduleID, returning its ID so callers can stamp it onto every task they
+// create for this firing. It is meant to be called once per cron firing,
+// never once per task; FireRetentionSchedule is the in-package example of a
+// firing that calls it and stamps the result onto the task it spawns. A
+// runner loop outside this package that reads due rows out of SchedulesTable
+// for other kinds must do the same for its own firings.
+func CreateExecution(ctx context.Context, runner squirrel.StatementBuilderType, scheduleID, policyID string) (executionID string, err error) {
+	err = runner.Insert(ExecutionsTable).
+		Columns("schedule_id", "policy_id", "created_at").
+		Values(scheduleID, policyID, time.Now()).
+		Suffix("RETURNING id").
+		QueryRowContext(ctx).
+		Scan(&executionID)
+	if err != nil {
+		return "", fmt.Errorf("can not insert execution for schedule %q: %w", scheduleID, err)
+	}
+
+	return executionID, nil
+}
+
+// Get fetches a single execution together with its latest rollup counters.
+func (m *executionManager) Get(ctx context.Context, executionID string) (queue.Execution, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "executionManager.Get")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("execution.id", executionID)
+
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(m.db))
+
+	execution, err := scanExecution(builder.Select(executionColumns...).
+		From(ExecutionsTable).
+		LeftJoin(fmt.Sprintf("%s USING (id)", ExecutionRollupsTable)).
+		Where(squirrel.Eq{"id": executionID}).
+		QueryRowContext(ctx))
+	if err != nil {
+		return queue.Execution{}, fmt.Errorf("can not get execution %q: %w", executionID, err)
+	}
+
+	return execution, nil
+}
+
+// List returns every execution spawned under policyID, most recent first.
+func (m *executionManager) List(ctx context.Context, policyID string) ([]queue.Execution, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "executionManager.List")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("policy.id", policyID)
+
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(m.db))
+
+	rows, err := builder.Select(executionColumns...).
+		From(ExecutionsTable).
+		LeftJoin(fmt.Sprintf("%s USING (id)", ExecutionRollupsTable)).
+		Where(squirrel.Eq{"policy_id": policyID}).
+		OrderBy("created_at DESC").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can not list executions for policy %q: %w", policyID, err)
+	}
+	defer rows.Close()
+
+	var executions []queue.Execution
+	for rows.Next() {
+		execution, err := scanExecution(rows)
+		if err != nil {
+			return nil, fmt.Errorf("can not scan execution row: %w", err)
+		}
+		executions = append(executions, execution)
+	}
+
+	return executions, rows.Err()
+}
+
+// executionColumns are the columns scanExecution expects, in order, from a
+// query that joins ExecutionsTable with ExecutionRollupsTable.
+var executionColumns = []string{
+	"id",
+	"schedule_id",
+	"policy_id",
+	"created_at",
+	"coalesce(total, 0)",
+	"coalesce(succeeded, 0)",
+	"coalesce(failed, 0)",
+	"coalesce(in_progress, 0)",
+	"coalesce(stopped, 0)",
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExecution(row rowScanner) (queue.Execution, error) {
+	var e queue.Execution
+	err := row.Scan(
+		&e.ID,
+		&e.ScheduleID,
+		&e.PolicyID,
+		&e.CreatedAt,
+		&e.Counters.Total,
+		&e.Counters.Succeeded,
+		&e.Counters.Failed,
+		&e.Counters.InProgress,
+		&e.Counters.Stopped,
+	)
+	return e, err
+}
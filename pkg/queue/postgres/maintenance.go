@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	cdb "github.com/contiamo/go-base/v2/pkg/db"
+	"github.com/contiamo/go-base/v2/pkg/queue"
+	"github.com/opentracing/opentracing-go"
+)
+
+// MaintenanceKind identifies a pluggable periodic janitor scheduled onto
+// MaintenanceTaskQueue, e.g. retention, vacuum/reindex, stuck-task recovery.
+// It doubles as the queue.TaskType of the tasks it schedules.
+type MaintenanceKind string
+
+// MaintenanceSpec is the task spec for a single maintenance schedule. Kinds
+// implement it on their own JSON-serialisable spec type, alongside
+// retentionTaskSpec's existing handlers.SQLExecTaskSpec embedding.
+type MaintenanceSpec interface {
+	// Kind identifies which registered maintenance kind this spec belongs to.
+	Kind() MaintenanceKind
+	// IdentityFields returns the `task_spec` JSON fields that make a schedule
+	// of this kind unique, e.g. {"queueName": "exports", "status": "finished"}.
+	// AssertMaintenanceSchedule dedupes existing schedules by (kind, these fields)
+	// instead of a kind-specific hardcoded column triple.
+	IdentityFields() map[string]string
+}
+
+// CronDistributor produces the cron expression for a newly created schedule
+// of a maintenance kind, e.g. spreading execution randomly across the hour
+// so that many schedules of the same kind don't all fire at once.
+type CronDistributor func() string
+
+// MaintenanceKindRegistration is what a maintenance kind registers with
+// RegisterMaintenanceKind: how to build its handler and how to pick the
+// cron schedule for newly asserted instances.
+type MaintenanceKindRegistration struct {
+	NewHandler func(db *sql.DB) queue.TaskHandler
+	Distribute CronDistributor
+}
+
+// maintenanceKinds holds every MaintenanceKind registered via
+// RegisterMaintenanceKind, keyed by kind. Kinds are expected to register
+// themselves from an init() in the file that defines them, mirroring
+// retention.go's registration of RetentionTask.
+var maintenanceKinds = map[MaintenanceKind]MaintenanceKindRegistration{}
+
+// RegisterMaintenanceKind makes a maintenance kind known to
+// AssertMaintenanceSchedule. Calling it twice for the same kind overwrites
+// the previous registration.
+func RegisterMaintenanceKind(kind MaintenanceKind, reg MaintenanceKindRegistration) {
+	maintenanceKinds[kind] = reg
+}
+
+// AssertMaintenanceSchedule creates or updates the periodic schedule for
+// spec, deduping against existing schedules of the same kind by
+// spec.IdentityFields() rather than a hardcoded set of columns. This is the
+// generalisation of what used to be retention-only scheduling logic, so
+// that new janitors (vacuum, stuck-task recovery, orphan cleanup, ...) can
+// reuse the same LOCK/exists/update dance just by registering a kind.
+func AssertMaintenanceSchedule(ctx context.Context, db *sql.DB, spec MaintenanceSpec) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AssertMaintenanceSchedule")
+	defer span.Finish()
+	span.SetTag("pkg.name", "postgres")
+	span.SetTag("maintenance.kind", string(spec.Kind()))
+
+	reg, ok := maintenanceKinds[spec.Kind()]
+	if !ok {
+		return fmt.Errorf("no maintenance kind registered for %q", spec.Kind())
+	}
+
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("can not build maintenance task spec: %w", err)
+	}
+
+	schedule := queue.TaskScheduleRequest{
+		TaskBase: queue.TaskBase{
+			Queue: MaintenanceTaskQueue,
+			Type:  queue.TaskType(spec.Kind()),
+			Spec:  specBytes,
+		},
+		CronSchedule: reg.Distribute(),
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can not start transaction for scheduling: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE schedules IN ACCESS EXCLUSIVE MODE;`)
+	if err != nil {
+		return fmt.Errorf("failed to lock `schedules`: %w", err)
+	}
+
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(tx))
+
+	identityEq := squirrel.Eq{
+		"task_queue": MaintenanceTaskQueue,
+		"task_type":  spec.Kind(),
+	}
+	for field, value := range spec.IdentityFields() {
+		identityEq[fmt.Sprintf("task_spec->>'%s'", field)] = value
+	}
+
+	var exists int
+	// use a unique error name here otherwise the sql.ErrNoRows might shadow
+	// us and things will break. This is also handled by the named error return
+	// variable, but this makes the code easier to copy and paste
+	existsErr := builder.Select("1").
+		From(SchedulesTable).
+		Where(identityEq).
+		ScanContext(ctx, &exists)
+	if existsErr != nil && existsErr != sql.ErrNoRows {
+		return fmt.Errorf("can not verify existing schedule: %w", existsErr)
+	}
+
+	// exists is only non-zero if the query succeeded and a schedule was found
+	if exists == 0 {
+		span.SetTag("created", true)
+		// pass nil db because it doesn't need the raw db
+		return NewScheduler(nil).Schedule(ctx, builder, schedule)
+	}
+
+	span.SetTag("updated", true)
+	res, err := builder.Update(SchedulesTable).
+		Where(identityEq).
+		Set("updated_at", time.Now()).
+		Set("task_spec", schedule.Spec).
+		Set("cron_schedule", schedule.CronSchedule).
+		Set("next_execution_time", time.Now()).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("can not update existing schdule: %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("can not determine the number of rows affected: %w", err)
+	}
+
+	span.SetTag("affected", updated)
+
+	return nil
+}
@@ -6,13 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/squirrel"
 	cdb "github.com/contiamo/go-base/v2/pkg/db"
 	"github.com/contiamo/go-base/v2/pkg/queue"
 	"github.com/contiamo/go-base/v2/pkg/queue/handlers"
-	"github.com/opentracing/opentracing-go"
+	"github.com/lib/pq"
 )
 
 const (
@@ -20,48 +21,124 @@ const (
 	// These are internal queue internal tasks
 	MaintenanceTaskQueue string = "queue-maintenance"
 
-	// RetentionTask is finished task cleanup type
-	RetentionTask queue.TaskType = "retention"
+	// RetentionTask is the maintenance kind for finished task cleanup
+	RetentionTask MaintenanceKind = "retention"
 )
 
+func init() {
+	RegisterMaintenanceKind(RetentionTask, MaintenanceKindRegistration{
+		NewHandler: NewRetentionHandler,
+		// randomly distribute the retention tasks throughout the hour
+		Distribute: func() string {
+			return fmt.Sprintf("%d * * * *", rand.Intn(60))
+		},
+	})
+}
+
+// RetentionPolicy describes which finished tasks a retention schedule
+// should remove. At least one of Age, KeepLastN or MaxRows must be set, and
+// all that are set are applied as independent rules: a task is deleted if
+// it matches any one of them.
+type RetentionPolicy struct {
+	QueueName string
+	TaskType  queue.TaskType
+	Status    queue.TaskStatus
+
+	// Age deletes finished tasks older than this, as before.
+	Age time.Duration
+
+	// KeepLastN retains the N most recent finished tasks matching
+	// QueueName/TaskType/Status/Scope regardless of age, deleting the rest.
+	KeepLastN int
+
+	// MaxRows is a hard cap on matching rows, evicting the oldest first once
+	// exceeded. It is meant as a safety net alongside Age/KeepLastN rather
+	// than the primary rule, so when both KeepLastN and MaxRows are set the
+	// smaller of the two wins.
+	MaxRows int
+
+	// Scope restricts the policy to tasks whose task_spec carries these
+	// key/value pairs, e.g. {"tenantID": "acme"} for a per-tenant policy.
+	Scope map[string]string
+
+	// DryRun, when set, makes the schedule report what it would delete
+	// instead of deleting it. See AssertRetentionScheduleDryRun.
+	DryRun bool
+}
+
 // retentionTaskSpec defines a SQL task to remove completed tasks that match given criteria.
 type retentionTaskSpec struct {
 	handlers.SQLExecTaskSpec
-	QueueName string           `json:"queueName"`
-	TaskType  queue.TaskType   `json:"taskType"`
-	Status    queue.TaskStatus `json:"status"`
-	Age       time.Duration    `json:"age"`
+	QueueName string            `json:"queueName"`
+	TaskType  queue.TaskType    `json:"taskType"`
+	Status    queue.TaskStatus  `json:"status"`
+	Age       time.Duration     `json:"age,omitempty"`
+	KeepLastN int               `json:"keepLastN,omitempty"`
+	MaxRows   int               `json:"maxRows,omitempty"`
+	Scope     map[string]string `json:"scope,omitempty"`
+	DryRun    bool              `json:"dryRun,omitempty"`
+	// Predicate is the rendered "id IN (...)" clause matching the tasks this
+	// policy targets, with $N placeholders. It is stored alongside SQL (the
+	// statement to run) so that a dry-run handler can reuse it to pull a
+	// sample of matching IDs without re-deriving the policy from the other
+	// fields. PredicateArgs holds the values for its placeholders in order;
+	// both must be passed together to whatever runs SQL or Predicate.
+	Predicate     string        `json:"predicate"`
+	PredicateArgs []interface{} `json:"predicateArgs,omitempty"`
 }
 
-// NewRetentionHandler creates a task handler that will clean up old finished tasks
+// Kind implements MaintenanceSpec
+func (s retentionTaskSpec) Kind() MaintenanceKind {
+	return RetentionTask
+}
+
+// IdentityFields implements MaintenanceSpec. A retention schedule is
+// uniquely identified by the queue/type/status/scope it cleans up - age,
+// keepLastN and maxRows are intentionally excluded so that tightening or
+// loosening the limits of an existing schedule updates it in place instead
+// of creating a second, competing schedule.
+func (s retentionTaskSpec) IdentityFields() map[string]string {
+	fields := map[string]string{
+		"status":    string(s.Status),
+		"queueName": s.QueueName,
+		"taskType":  string(s.TaskType),
+	}
+	if len(s.Scope) > 0 {
+		// task_spec->>'scope' on an object column returns its JSON text, so
+		// a single field comparison is enough to key on the whole scope map.
+		// encoding/json sorts map keys, so this is stable across calls.
+		scopeJSON, _ := json.Marshal(s.Scope)
+		fields["scope"] = string(scopeJSON)
+	}
+	return fields
+}
+
+// retentionHandler runs the retention task's SQL and reports the outcome via
+// an OnRetentionCompleted callback event, or, for a dry-run schedule,
+// records a preview instead.
+type retentionHandler struct {
+	db *sql.DB
+}
+
+// NewRetentionHandler creates a task handler that will clean up old finished
+// tasks, or, for a dry-run schedule, record a preview of what it would do.
 func NewRetentionHandler(db *sql.DB) queue.TaskHandler {
-	return handlers.NewSQLTaskHandler("RetentionHandler", db)
+	return &retentionHandler{db: db}
 }
 
-// AssertRetentionSchedule creates a new queue retention tasks for the supplied queue, finished tasks matching
-// the supplied parameters will be deleted
-func AssertRetentionSchedule(ctx context.Context, db *sql.DB, queueName string, taskType queue.TaskType, status queue.TaskStatus, age time.Duration) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "AssertRetentionSchedule")
-	span.SetTag("pkg.name", "postgres")
+func (h *retentionHandler) Handle(ctx context.Context, task queue.Task) (err error) {
+	var spec retentionTaskSpec
+	if err = json.Unmarshal(task.Spec, &spec); err != nil {
+		return fmt.Errorf("RetentionHandler: can not unmarshal task spec: %w", err)
+	}
 
-	spec := createRetentionSpec(queueName, taskType, status, age)
-	specBytes, err := json.Marshal(spec)
-	if err != nil {
-		return fmt.Errorf("can not build retention task spec: %w", err)
-	}
-	// randomly distribute the retention tasks throughout the hour
-	when := rand.Intn(60)
-	retentionSchedule := queue.TaskScheduleRequest{
-		TaskBase: queue.TaskBase{
-			Queue: MaintenanceTaskQueue,
-			Type:  RetentionTask,
-			Spec:  specBytes,
-		},
-		CronSchedule: fmt.Sprintf("%d * * * *", when), // every hour at minute "when"
+	if spec.DryRun {
+		return h.preview(ctx, task.ExecutionID, spec)
 	}
-	tx, err := db.BeginTx(ctx, nil)
+
+	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("can not start transaction for scheduling: %w", err)
+		return fmt.Errorf("RetentionHandler: can not start transaction: %w", err)
 	}
 	defer func() {
 		if err != nil {
@@ -71,94 +148,200 @@ func AssertRetentionSchedule(ctx context.Context, db *sql.DB, queueName string,
 		err = tx.Commit()
 	}()
 
-	_, err = tx.ExecContext(ctx, `LOCK TABLE schedules IN ACCESS EXCLUSIVE MODE;`)
+	res, err := tx.ExecContext(ctx, spec.SQL, spec.PredicateArgs...)
+	if err != nil {
+		return fmt.Errorf("RetentionHandler: can not execute task sql: %w", err)
+	}
+
+	rowsDeleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("RetentionHandler: can not determine rows deleted: %w", err)
+	}
+
+	event := RetentionCompleted{
+		QueueName:   spec.QueueName,
+		TaskType:    spec.TaskType,
+		Status:      spec.Status,
+		RowsDeleted: rowsDeleted,
+	}
+	if err = enqueueEvent(ctx, tx, retentionCompletedEvent, event); err != nil {
+		return fmt.Errorf("RetentionHandler: can not enqueue completion event: %w", err)
+	}
+
+	return nil
+}
+
+// AssertRetentionSchedule creates or updates the recurring schedule row
+// enforcing policy; it does not itself spawn any tasks. Each firing of that
+// schedule should go through FireRetentionSchedule, not a direct insert into
+// TasksTable, so that it gets its own execution row - see FireRetentionSchedule's
+// doc comment for what still has to call it.
+func AssertRetentionSchedule(ctx context.Context, db *sql.DB, policy RetentionPolicy) error {
+	spec, err := createRetentionSpec(policy)
+	if err != nil {
+		return err
+	}
+	return AssertMaintenanceSchedule(ctx, db, spec)
+}
+
+// AssertRetentionScheduleDryRun creates or updates a retention schedule that
+// reports what it would delete, via PreviewRetention-style counts recorded
+// into RetentionPreviewsTable, instead of actually deleting anything.
+func AssertRetentionScheduleDryRun(ctx context.Context, db *sql.DB, policy RetentionPolicy) error {
+	policy.DryRun = true
+	return AssertRetentionSchedule(ctx, db, policy)
+}
+
+// FireRetentionSchedule spawns one retention task for a single firing of the
+// schedule scheduleID/policyID, creating its execution row and stamping the
+// execution ID onto the spawned task in the same transaction, so that
+// ExecutionRollupTask's `WHERE execution_id IS NOT NULL` grouping,
+// StopAndWait's per-execution lookup and the dry-run preview all have
+// something to key off of for it.
+//
+// Nothing in this package calls FireRetentionSchedule: reading due rows out
+// of SchedulesTable and firing them - the "cron tick" - is still the
+// responsibility of a runner loop outside this package. Unlike
+// CreateExecution before it, that runner loop no longer has to reimplement
+// the execution/task bookkeeping itself - calling FireRetentionSchedule once
+// per firing of a retention schedule is now the whole job.
+func FireRetentionSchedule(ctx context.Context, db *sql.DB, scheduleID, policyID string, policy RetentionPolicy) (taskID string, err error) {
+	spec, err := createRetentionSpec(policy)
+	if err != nil {
+		return "", err
+	}
+
+	specBytes, err := json.Marshal(spec)
 	if err != nil {
-		return fmt.Errorf("failed to lock `schedules`: %w", err)
+		return "", fmt.Errorf("can not build retention task spec: %w", err)
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("can not start transaction for firing retention schedule %q: %w", scheduleID, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
 	builder := squirrel.StatementBuilder.
 		PlaceholderFormat(squirrel.Dollar).
 		RunWith(cdb.WrapWithTracing(tx))
 
-	var exists int
-	// use a unique error name here otherwise the sql.ErrNoRows might shadow
-	// us and things will break. This is also handled by the named error return
-	// variable, but this makes the code easier to copy and paste
-	existsErr := builder.Select("1").
-		From("schedules").
-		Where(squirrel.Eq{
-			"task_queue":              MaintenanceTaskQueue,
-			"task_type":               RetentionTask,
-			"task_spec->>'queueName'": queueName,
-			"task_spec->>'taskType'":  taskType,
-			"task_spec->>'status'":    status,
-		}).ScanContext(ctx, &exists)
-	if existsErr != nil && existsErr != sql.ErrNoRows {
-		return fmt.Errorf("can not verify existing schedule: %w", existsErr)
-	}
-
-	// will only non-zero if err is nil and task is not found
-	if exists == 0 {
-		span.SetTag("created", true)
-		// pass nil db because it doesn't need the raw db
-		return NewScheduler(nil).Schedule(ctx, builder, retentionSchedule)
-	}
-
-	span.SetTag("updated", true)
-	res, err := builder.Update("schedules").
-		Where(squirrel.Eq{
-			"task_queue":              MaintenanceTaskQueue,
-			"task_type":               RetentionTask,
-			"task_spec->>'queueName'": queueName,
-			"task_spec->>'taskType'":  taskType,
-			"task_spec->>'status'":    status,
-		}).
-		Set("updated_at", time.Now()).
-		Set("task_spec", retentionSchedule.Spec).
-		Set("cron_schedule", retentionSchedule.CronSchedule).
-		Set("next_execution_time", time.Now()).
-		ExecContext(ctx)
+	executionID, err := CreateExecution(ctx, builder, scheduleID, policyID)
 	if err != nil {
-		return fmt.Errorf("can not update existing schdule: %w", err)
+		return "", err
 	}
 
-	updated, err := res.RowsAffected()
+	err = builder.Insert(TasksTable).
+		Columns("queue", "type", "spec", "execution_id", "status", "created_at").
+		Values(MaintenanceTaskQueue, RetentionTask, specBytes, executionID, queue.StatusPending, time.Now()).
+		Suffix("RETURNING id").
+		QueryRowContext(ctx).
+		Scan(&taskID)
 	if err != nil {
-		return fmt.Errorf("can not determine the number of rows affected: %w", err)
+		return "", fmt.Errorf("can not insert retention task for schedule %q: %w", scheduleID, err)
 	}
 
-	span.SetTag("affected", updated)
-
-	return nil
+	return taskID, nil
 }
 
-//createRetentionSpec builds the task retention job spec. It is split out to simplify test setup
-func createRetentionSpec(queueName string, taskType queue.TaskType, status queue.TaskStatus, age time.Duration) retentionTaskSpec {
+// createRetentionSpec builds the task retention job spec. It is split out to simplify test setup
+func createRetentionSpec(policy RetentionPolicy) (retentionTaskSpec, error) {
 	spec := retentionTaskSpec{
-		QueueName: queueName,
-		TaskType:  taskType,
-		Status:    status,
-		Age:       age,
+		QueueName: policy.QueueName,
+		TaskType:  policy.TaskType,
+		Status:    policy.Status,
+		Age:       policy.Age,
+		KeepLastN: policy.KeepLastN,
+		MaxRows:   policy.MaxRows,
+		Scope:     policy.Scope,
+		DryRun:    policy.DryRun,
 	}
 
 	// use separate WHERE statements to make the order deterministic
-	deletionSQL := squirrel.Delete(TasksTable).
-		Where(squirrel.Eq{"status": status}).
-		Where(
+	base := squirrel.Select("id").
+		From(TasksTable).
+		Where(squirrel.Eq{"status": policy.Status})
+
+	if policy.QueueName != "" {
+		base = base.Where(squirrel.Eq{"queue": policy.QueueName})
+	}
+	if policy.TaskType != "" {
+		base = base.Where(squirrel.Eq{"type": policy.TaskType})
+	}
+	for field, value := range policy.Scope {
+		// the ->> operator's right-hand side is a JSON key given as a SQL
+		// string literal, not an identifier, so a Scope field name containing
+		// a quote needs literal-quoting here, not identifier-quoting.
+		base = base.Where(squirrel.Eq{fmt.Sprintf("task_spec->>%s", pq.QuoteLiteral(field)): value})
+	}
+
+	var rules []string
+	var args []interface{}
+
+	if policy.Age > 0 {
+		ageRule := base.Where(
 			// note that using this comparision allows us to use the index on
 			// finished_at, if yo use `age(now(), finished_at)`, this can not use the index
-			fmt.Sprintf("finished_at <= now() - interval '%f minutes'", age.Minutes()),
+			fmt.Sprintf("finished_at <= now() - interval '%f minutes'", policy.Age.Minutes()),
 		)
+		sql, ruleArgs, err := ageRule.ToSql()
+		if err != nil {
+			return retentionTaskSpec{}, fmt.Errorf("can not build age retention rule: %w", err)
+		}
+		rules = append(rules, fmt.Sprintf("(%s)", sql))
+		args = append(args, ruleArgs...)
+	}
 
-	if queueName != "" {
-		deletionSQL = deletionSQL.Where(squirrel.Eq{"queue": queueName})
+	keepN := policy.KeepLastN
+	if policy.MaxRows > 0 && (keepN == 0 || policy.MaxRows < keepN) {
+		keepN = policy.MaxRows
+	}
+	if keepN > 0 {
+		// keep the N most recent matching rows, delete the rest - the
+		// OFFSET keeps this index-friendly since it still orders by the
+		// indexed finished_at column rather than computing an age.
+		windowRule := base.OrderBy("finished_at DESC").Offset(uint64(keepN))
+		sql, ruleArgs, err := windowRule.ToSql()
+		if err != nil {
+			return retentionTaskSpec{}, fmt.Errorf("can not build keep-last-n retention rule: %w", err)
+		}
+		rules = append(rules, fmt.Sprintf("(%s)", sql))
+		args = append(args, ruleArgs...)
 	}
 
-	if taskType != "" {
-		deletionSQL = deletionSQL.Where(squirrel.Eq{"type": taskType})
+	if len(rules) == 0 {
+		return retentionTaskSpec{}, fmt.Errorf("retention policy must set at least one of Age, KeepLastN or MaxRows")
 	}
 
-	spec.SQL = squirrel.DebugSqlizer(deletionSQL)
+	// each branch is parenthesized above: a bare UNION's trailing ORDER
+	// BY/OFFSET binds to the combined result set and can only reference a
+	// column the union itself selects (just "id" here), not windowRule's own
+	// finished_at - parens keep the ORDER BY/OFFSET scoped to that branch.
+	//
+	// each branch above is built with the default "?" placeholders and its
+	// own args; renumber them together now that they're combined into one
+	// expression, rather than rendering any value as a literal.
+	predicateSQL, err := squirrel.Dollar.ReplacePlaceholders(fmt.Sprintf("id IN (%s)", strings.Join(rules, " UNION ")))
+	if err != nil {
+		return retentionTaskSpec{}, fmt.Errorf("can not render retention predicate: %w", err)
+	}
+	spec.Predicate = predicateSQL
+	spec.PredicateArgs = args
+
+	if policy.DryRun {
+		spec.SQL = fmt.Sprintf(
+			"SELECT count(*), min(finished_at), max(finished_at) FROM %s WHERE %s",
+			TasksTable, spec.Predicate,
+		)
+	} else {
+		spec.SQL = fmt.Sprintf("DELETE FROM %s WHERE %s", TasksTable, spec.Predicate)
+	}
 
-	return spec
+	return spec, nil
 }
@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	cdb "github.com/contiamo/go-base/v2/pkg/db"
+	"github.com/lib/pq"
+)
+
+// previewSampleSize caps how many matching task IDs a preview records, so
+// that previewing a policy that matches millions of rows doesn't itself
+// become expensive.
+const previewSampleSize = 20
+
+// RetentionPreview is the projected impact of a retention policy: how many
+// tasks it matches, the age range they span, and a bounded sample of their
+// IDs for spot-checking before enabling deletion.
+type RetentionPreview struct {
+	MatchedCount  int
+	MinFinishedAt *time.Time
+	MaxFinishedAt *time.Time
+	SampleTaskIDs []string
+}
+
+// PreviewRetention runs the predicate policy would use, synchronously,
+// without scheduling or deleting anything. It's meant for operators to
+// sanity-check a policy - including KeepLastN/MaxRows/Scope, not just
+// QueueName/TaskType/Status/Age - before calling AssertRetentionSchedule
+// with it. policy.DryRun is ignored; PreviewRetention always previews.
+func PreviewRetention(ctx context.Context, db *sql.DB, policy RetentionPolicy) (RetentionPreview, error) {
+	policy.DryRun = true
+	spec, err := createRetentionSpec(policy)
+	if err != nil {
+		return RetentionPreview{}, err
+	}
+
+	var preview RetentionPreview
+	var minFinished, maxFinished sql.NullTime
+	err = db.QueryRowContext(ctx, spec.SQL, spec.PredicateArgs...).
+		Scan(&preview.MatchedCount, &minFinished, &maxFinished)
+	if err != nil {
+		return RetentionPreview{}, fmt.Errorf("can not count matching tasks: %w", err)
+	}
+	preview.MinFinishedAt = nullableTime(minFinished)
+	preview.MaxFinishedAt = nullableTime(maxFinished)
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id FROM %s WHERE %s LIMIT %d", TasksTable, spec.Predicate, previewSampleSize,
+	), spec.PredicateArgs...)
+	if err != nil {
+		return RetentionPreview{}, fmt.Errorf("can not sample matching tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return RetentionPreview{}, fmt.Errorf("can not scan sampled task id: %w", err)
+		}
+		preview.SampleTaskIDs = append(preview.SampleTaskIDs, id)
+	}
+
+	return preview, rows.Err()
+}
+
+// preview runs spec's predicate as a count/min/max instead of a delete, and
+// records the result into RetentionPreviewsTable keyed by executionID so it
+// can be looked up after the dry-run task completes.
+func (h *retentionHandler) preview(ctx context.Context, executionID string, spec retentionTaskSpec) error {
+	var matched int
+	var minFinished, maxFinished sql.NullTime
+	err := h.db.QueryRowContext(ctx, spec.SQL, spec.PredicateArgs...).Scan(&matched, &minFinished, &maxFinished)
+	if err != nil {
+		return fmt.Errorf("RetentionHandler: can not compute dry-run preview: %w", err)
+	}
+
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT id FROM %s WHERE %s LIMIT %d", TasksTable, spec.Predicate, previewSampleSize,
+	), spec.PredicateArgs...)
+	if err != nil {
+		return fmt.Errorf("RetentionHandler: can not sample dry-run matches: %w", err)
+	}
+	defer rows.Close()
+
+	var sampleIDs []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return fmt.Errorf("RetentionHandler: can not scan sampled task id: %w", err)
+		}
+		sampleIDs = append(sampleIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	builder := squirrel.StatementBuilder.
+		PlaceholderFormat(squirrel.Dollar).
+		RunWith(cdb.WrapWithTracing(h.db))
+
+	_, err = builder.Insert(RetentionPreviewsTable).
+		Columns("execution_id", "matched_count", "min_finished_at", "max_finished_at", "sample_task_ids", "created_at").
+		Values(executionID, matched, nullableTime(minFinished), nullableTime(maxFinished), pq.Array(sampleIDs), time.Now()).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("RetentionHandler: can not record dry-run preview: %w", err)
+	}
+
+	return nil
+}
+
+func nullableTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
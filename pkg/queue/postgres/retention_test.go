@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contiamo/go-base/v2/pkg/queue"
+)
+
+// TestCreateRetentionSpec_AgeAndKeepLastN guards against a regression where
+// combining Age with KeepLastN/MaxRows produced a bare `UNION` whose
+// trailing `ORDER BY finished_at DESC OFFSET n` bound to the combined
+// result set instead of the KeepLastN branch - a hard SQL error in postgres,
+// since the union only selects `id`, not `finished_at`.
+func TestCreateRetentionSpec_AgeAndKeepLastN(t *testing.T) {
+	spec, err := createRetentionSpec(RetentionPolicy{
+		QueueName: "emails",
+		Status:    queue.StatusSucceeded,
+		Age:       time.Hour,
+		KeepLastN: 100,
+	})
+	if err != nil {
+		t.Fatalf("createRetentionSpec: %v", err)
+	}
+
+	inner := strings.TrimPrefix(spec.Predicate, "id IN (")
+	inner = strings.TrimSuffix(inner, ")")
+
+	branches := strings.Split(inner, " UNION ")
+	if len(branches) != 2 {
+		t.Fatalf("expected 2 UNION branches, got %d: %s", len(branches), spec.Predicate)
+	}
+
+	for _, branch := range branches {
+		if !strings.HasPrefix(branch, "(") || !strings.HasSuffix(branch, ")") {
+			t.Errorf("UNION branch not parenthesized: %s", branch)
+		}
+	}
+
+	// the ORDER BY/OFFSET must stay inside the KeepLastN branch's own
+	// parens, not trail the whole predicate where it would bind to the
+	// union's output columns (just "id") instead of that branch.
+	if !strings.Contains(branches[1], "ORDER BY finished_at DESC OFFSET 100") {
+		t.Errorf("expected KeepLastN branch to carry its own ORDER BY/OFFSET: %s", branches[1])
+	}
+	if strings.Contains(branches[0], "OFFSET") {
+		t.Errorf("expected age branch to carry no OFFSET: %s", branches[0])
+	}
+}
+
+// TestCreateRetentionSpec_ScopeIsParameterized guards against a regression
+// where Scope values were rendered as unescaped SQL literals: a tenant value
+// containing a quote must end up in PredicateArgs, not spliced into the
+// predicate text, and the JSON field name must be quoted as a string literal
+// rather than interpolated raw.
+func TestCreateRetentionSpec_ScopeIsParameterized(t *testing.T) {
+	spec, err := createRetentionSpec(RetentionPolicy{
+		Status: queue.StatusSucceeded,
+		Age:    time.Hour,
+		Scope:  map[string]string{"tenantID": "acme'; DROP TABLE tasks; --"},
+	})
+	if err != nil {
+		t.Fatalf("createRetentionSpec: %v", err)
+	}
+
+	if strings.Contains(spec.Predicate, "DROP TABLE") {
+		t.Fatalf("Scope value leaked into the predicate text instead of being parameterized: %s", spec.Predicate)
+	}
+
+	found := false
+	for _, arg := range spec.PredicateArgs {
+		if arg == "acme'; DROP TABLE tasks; --" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the Scope value to be carried as a bound arg, got %v", spec.PredicateArgs)
+	}
+
+	if !strings.Contains(spec.Predicate, `task_spec->>'tenantID'`) {
+		t.Errorf("expected the Scope field name quoted as a literal in the predicate: %s", spec.Predicate)
+	}
+}
+
+// TestFireRetentionSchedule_InvalidPolicy guards that FireRetentionSchedule
+// validates its policy (via createRetentionSpec) before touching the
+// database, so a bad policy fails fast with db left nil in this test.
+func TestFireRetentionSchedule_InvalidPolicy(t *testing.T) {
+	_, err := FireRetentionSchedule(nil, nil, "schedule-1", "policy-1", RetentionPolicy{
+		Status: queue.StatusSucceeded,
+	})
+	if err == nil {
+		t.Fatalf("FireRetentionSchedule: expected an error for a policy with no Age/KeepLastN/MaxRows")
+	}
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/contiamo/go-base/v2/pkg/queue"
+)
+
+// ExecutionRollupTask is the maintenance kind that recomputes
+// ExecutionRollupsTable from the current state of TasksTable. Keeping the
+// rollup as a periodic pass rather than an eager update means it stays
+// consistent even when a task crashes mid-write, instead of drifting out of
+// sync with whatever the last successful write happened to leave behind.
+const ExecutionRollupTask MaintenanceKind = "execution-rollup"
+
+func init() {
+	RegisterMaintenanceKind(ExecutionRollupTask, MaintenanceKindRegistration{
+		NewHandler: NewExecutionRollupHandler,
+		// the rollup is cheap and every execution benefits from staying
+		// fresh, so run it every minute rather than distributing it.
+		Distribute: func() string { return "* * * * *" },
+	})
+}
+
+// executionRollupTaskSpec has no parameters: a single schedule recomputes
+// the rollup for every execution, so there is nothing to dedupe against
+// beyond the kind itself.
+type executionRollupTaskSpec struct{}
+
+// Kind implements MaintenanceSpec.
+func (executionRollupTaskSpec) Kind() MaintenanceKind {
+	return ExecutionRollupTask
+}
+
+// IdentityFields implements MaintenanceSpec.
+func (executionRollupTaskSpec) IdentityFields() map[string]string {
+	return nil
+}
+
+// AssertExecutionRollupSchedule registers the single, recurring execution
+// rollup schedule. It is idempotent: calling it again updates the existing
+// schedule in place instead of adding a second one.
+func AssertExecutionRollupSchedule(ctx context.Context, db *sql.DB) error {
+	return AssertMaintenanceSchedule(ctx, db, executionRollupTaskSpec{})
+}
+
+// executionRollupHandler recomputes ExecutionRollupsTable from TasksTable.
+type executionRollupHandler struct {
+	db *sql.DB
+}
+
+// NewExecutionRollupHandler creates the queue.TaskHandler backing
+// ExecutionRollupTask.
+func NewExecutionRollupHandler(db *sql.DB) queue.TaskHandler {
+	return &executionRollupHandler{db: db}
+}
+
+func (h *executionRollupHandler) Handle(ctx context.Context, task queue.Task) error {
+	_, err := h.db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (id, total, succeeded, failed, in_progress, stopped, updated_at)
+		SELECT
+			execution_id,
+			count(*),
+			count(*) FILTER (WHERE status = '%s'),
+			count(*) FILTER (WHERE status = '%s'),
+			count(*) FILTER (WHERE status IN ('%s', '%s', '%s')),
+			count(*) FILTER (WHERE status = '%s')
+			, now()
+		FROM %s
+		WHERE execution_id IS NOT NULL
+		GROUP BY execution_id
+		ON CONFLICT (id) DO UPDATE SET
+			total = EXCLUDED.total,
+			succeeded = EXCLUDED.succeeded,
+			failed = EXCLUDED.failed,
+			in_progress = EXCLUDED.in_progress,
+			stopped = EXCLUDED.stopped,
+			updated_at = EXCLUDED.updated_at
+	`,
+		ExecutionRollupsTable,
+		queue.StatusSucceeded,
+		queue.StatusFailed,
+		queue.StatusPending, queue.StatusRunning, queue.StatusStopping,
+		queue.StatusCancelled,
+		TasksTable,
+	))
+	if err != nil {
+		return fmt.Errorf("can not recompute execution rollups: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/contiamo/go-base/v2/pkg/queue"
+	"github.com/lib/pq"
+)
+
+// SchedulerOptions tunes how Claim scores and orders pending tasks. The zero
+// value of each field falls back to its DefaultSchedulerOptions default.
+type SchedulerOptions struct {
+	// AgeBonusDenom controls how quickly a waiting task's score grows with
+	// age: a task accrues (seconds waited / AgeBonusDenom) points.
+	AgeBonusDenom float64
+	// RetryPenalty is subtracted from a task's score once per retry, so
+	// repeatedly-failing tasks sink below fresh ones.
+	RetryPenalty float64
+	// QueueWeights multiplies a task's score by its queue's weight, letting
+	// some queues be claimed preferentially over others. Queues not listed
+	// default to a weight of 1.0.
+	QueueWeights map[string]float64
+}
+
+// DefaultSchedulerOptions returns the SchedulerOptions used when NewScheduler
+// is called without one.
+func DefaultSchedulerOptions() SchedulerOptions {
+	return SchedulerOptions{
+		AgeBonusDenom: 300, // one point per five minutes waited
+		RetryPenalty:  0.1,
+	}
+}
+
+// Scheduler inserts recurring schedules and claimed tasks into postgres, and
+// tracks the in-flight work this process is currently running so that it can
+// be stopped on request.
+type Scheduler struct {
+	db   *sql.DB
+	opts SchedulerOptions
+
+	mu      sync.Mutex
+	running map[string]trackedTask // taskID -> context/cancel for the running handler
+}
+
+// trackedTask pairs the cancellable context a claimed task's handler must
+// run under with the func that cancels it, so Stop can signal an in-process
+// handler and the caller driving it can retrieve that same context.
+type trackedTask struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewScheduler creates a Scheduler backed by db. db may be nil when the
+// caller only intends to use the returned Scheduler within an existing
+// transaction passed explicitly to its methods (see AssertMaintenanceSchedule).
+// opts is optional; omitting it is equivalent to passing
+// DefaultSchedulerOptions().
+func NewScheduler(db *sql.DB, opts ...SchedulerOptions) *Scheduler {
+	o := DefaultSchedulerOptions()
+	if len(opts) > 0 {
+		if opts[0].AgeBonusDenom != 0 {
+			o.AgeBonusDenom = opts[0].AgeBonusDenom
+		}
+		if opts[0].RetryPenalty != 0 {
+			o.RetryPenalty = opts[0].RetryPenalty
+		}
+		if opts[0].QueueWeights != nil {
+			o.QueueWeights = opts[0].QueueWeights
+		}
+	}
+
+	return &Scheduler{
+		db:      db,
+		opts:    o,
+		running: make(map[string]trackedTask),
+	}
+}
+
+// Schedule inserts or replaces the recurring schedule described by req,
+// using runner for the insert so callers can participate in an existing
+// transaction.
+func (s *Scheduler) Schedule(ctx context.Context, runner squirrel.StatementBuilderType, req queue.TaskScheduleRequest) error {
+	_, err := runner.Insert(SchedulesTable).
+		Columns("task_queue", "task_type", "task_spec", "cron_schedule").
+		Values(req.Queue, req.Type, []byte(req.Spec), req.CronSchedule).
+		ExecContext(ctx)
+	if err != nil {
+		return fmt.Errorf("can not insert schedule: %w", err)
+	}
+
+	return nil
+}
+
+// Claim atomically picks up to limit pending tasks from queues, ordering
+// candidates by score - base priority, plus an age bonus for how long a task
+// has waited, minus a penalty per retry, scaled by the claiming queue's
+// weight - and marks them running. It follows the candidate-scoring approach
+// used by schedulers like Skia's task_scheduler: a forced/high-priority task
+// tops the list, repeatedly-retried tasks score lower than fresh ones, and
+// older candidates accrue a time bonus so nothing starves.
+//
+// The claim query filters on (queue, status) before sorting by score, so a
+// partial index on (queue, status) is enough to keep this fast; the score
+// itself is computed inline rather than stored, since it changes continuously
+// with a task's age.
+//
+// Claiming goes through transitionTasks like every other status change, so
+// an OnTaskStateChange callback fires for each claimed task the same way it
+// does for Stop/MarkError's transitions.
+//
+// Each claimed task is tracked so a later Stop can cancel it in-process: the
+// caller driving a claimed task's handler must fetch its context via Context
+// before calling TaskHandler.Handle, and must call Finish once Handle
+// returns so the task's cancel func is released and its terminal status
+// recorded.
+func (s *Scheduler) Claim(ctx context.Context, queues []string, limit int) (tasks []queue.Task, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can not start transaction for claiming tasks: %w", err)
+	}
+	// committed explicitly below, once the claim has succeeded, so that
+	// track only ever registers a task this process is certain it holds;
+	// this defer only ever fires the rollback half, on an earlier error.
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// left at the default "?" placeholder format: its SQL is spliced into
+	// the outer update below and its args are threaded alongside, so the
+	// whole statement is renumbered together when the outer query renders.
+	candidates := squirrel.Select("id").
+		From(TasksTable).
+		Where(squirrel.Eq{"status": queue.StatusPending}).
+		Where(squirrel.Eq{"queue": queues}).
+		OrderBy(s.scoreExpression() + " DESC").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED")
+
+	candidateSQL, candidateArgs, err := candidates.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("can not build claim candidates query: %w", err)
+	}
+
+	tasks, err = s.transitionTasks(ctx, tx, queue.StatusPending, queue.StatusRunning,
+		squirrel.Expr(fmt.Sprintf("id IN (%s)", candidateSQL), candidateArgs...),
+		map[string]interface{}{"started_at": time.Now()},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can not claim tasks: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("can not commit claimed tasks: %w", err)
+	}
+
+	for _, t := range tasks {
+		taskCtx, cancel := context.WithCancel(context.Background())
+		s.track(t.ID, taskCtx, cancel)
+	}
+
+	return tasks, nil
+}
+
+// scoreExpression renders the claim-order scoring formula as a SQL
+// expression: priority + age bonus - retry penalty, scaled by queue weight.
+func (s *Scheduler) scoreExpression() string {
+	score := fmt.Sprintf(
+		"(priority + EXTRACT(EPOCH FROM (now() - created_at))/%f - retry_count * %f)",
+		s.opts.AgeBonusDenom, s.opts.RetryPenalty,
+	)
+
+	if len(s.opts.QueueWeights) == 0 {
+		return score
+	}
+
+	queues := make([]string, 0, len(s.opts.QueueWeights))
+	for q := range s.opts.QueueWeights {
+		queues = append(queues, q)
+	}
+	sort.Strings(queues)
+
+	var weight strings.Builder
+	weight.WriteString("CASE queue ")
+	for _, q := range queues {
+		fmt.Fprintf(&weight, "WHEN %s THEN %f ", pq.QuoteLiteral(q), s.opts.QueueWeights[q])
+	}
+	weight.WriteString("ELSE 1.0 END")
+
+	return fmt.Sprintf("(%s) * (%s)", score, weight.String())
+}
+
+// track registers the context/cancel func for a task this process has
+// claimed and started running, so a later Stop/StopAndWait call can signal
+// it and Context can hand it to the caller driving the handler.
+func (s *Scheduler) track(taskID string, ctx context.Context, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running[taskID] = trackedTask{ctx: ctx, cancel: cancel}
+}
+
+// untrack removes a task's tracked context/cancel func once its handler has
+// returned, i.e. once Finish has recorded its terminal status.
+func (s *Scheduler) untrack(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, taskID)
+}
+
+// Context returns the cancellable context Claim created for taskID, which
+// the caller driving a claimed task's handler must pass to
+// TaskHandler.Handle so that Stop can interrupt it in-process. ok is false
+// once the task has been claimed by a different process, or after Finish has
+// released it.
+func (s *Scheduler) Context(taskID string) (ctx context.Context, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.running[taskID]
+	return t.ctx, ok
+}
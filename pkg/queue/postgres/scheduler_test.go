@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestScoreExpression_QueueWeights guards the CASE-expression shape
+// scoreExpression renders for per-queue weighting, since Claim's
+// ORDER BY relies on it parsing as valid SQL.
+func TestScoreExpression_QueueWeights(t *testing.T) {
+	s := NewScheduler(nil, SchedulerOptions{
+		QueueWeights: map[string]float64{"emails": 2, "exports": 0.5},
+	})
+
+	expr := s.scoreExpression()
+
+	for _, want := range []string{
+		"CASE queue",
+		"WHEN 'emails' THEN 2.000000",
+		"WHEN 'exports' THEN 0.500000",
+		"ELSE 1.0 END",
+	} {
+		if !strings.Contains(expr, want) {
+			t.Errorf("scoreExpression() = %q, want substring %q", expr, want)
+		}
+	}
+}
+
+// TestScoreExpression_NoWeights ensures the common case - no per-queue
+// weighting configured - renders the bare score with no CASE wrapper.
+func TestScoreExpression_NoWeights(t *testing.T) {
+	s := NewScheduler(nil, DefaultSchedulerOptions())
+
+	expr := s.scoreExpression()
+
+	if strings.Contains(expr, "CASE") {
+		t.Errorf("scoreExpression() = %q, did not expect a CASE expression with no QueueWeights", expr)
+	}
+}
+
+// BenchmarkScoreExpression exercises the hot-path formatting Claim runs once
+// per call to build its ORDER BY clause, so a regression that makes it
+// allocate heavily per call (e.g. from QueueWeights growing unexpectedly
+// large) shows up here rather than only under load. A full throughput
+// benchmark of Claim itself needs a live database and belongs in a
+// build-tagged integration benchmark instead of this package's unit tests.
+func BenchmarkScoreExpression(b *testing.B) {
+	s := NewScheduler(nil, SchedulerOptions{
+		QueueWeights: map[string]float64{"emails": 2, "exports": 0.5, "reports": 1.5},
+	})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = s.scoreExpression()
+	}
+}
@@ -0,0 +1,33 @@
+package postgres
+
+// Table names for the queue schema. Centralised here so that spec builders
+// across this package (retention, scheduling, rollups, ...) never drift
+// from one another when the schema changes.
+const (
+	// TasksTable stores individual units of work, one row per claimed or
+	// pending task.
+	TasksTable string = "tasks"
+
+	// SchedulesTable stores the cron-driven recurring schedules that spawn
+	// new tasks, e.g. the retention schedule created by AssertRetentionSchedule.
+	SchedulesTable string = "schedules"
+
+	// ExecutionsTable stores one row per logical trigger (a cron firing, or a
+	// manually requested run) that groups the tasks it spawned.
+	ExecutionsTable string = "executions"
+
+	// ExecutionRollupsTable stores the derived task-status counters for each
+	// execution, recomputed periodically by the execution rollup maintenance
+	// task rather than updated eagerly on every task state transition.
+	ExecutionRollupsTable string = "execution_rollups"
+
+	// RetentionPreviewsTable stores the projected impact of a dry-run
+	// retention schedule, one row per execution.
+	RetentionPreviewsTable string = "retention_previews"
+
+	// TaskEventsTable is the callback outbox: state-transition and
+	// completion events are inserted here in the same transaction as the
+	// write that caused them, then drained and dispatched to registered Go
+	// callbacks by the callback-dispatch maintenance task.
+	TaskEventsTable string = "task_events"
+)
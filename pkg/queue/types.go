@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// TaskType identifies what kind of work a task performs. Handlers are
+// registered against a TaskType so the scheduler knows how to dispatch a
+// claimed task.
+type TaskType string
+
+// TaskStatus is the lifecycle state of a single task.
+type TaskStatus string
+
+const (
+	// StatusPending means the task has been created but not yet claimed.
+	StatusPending TaskStatus = "pending"
+	// StatusRunning means a worker has claimed the task and is executing it.
+	StatusRunning TaskStatus = "running"
+	// StatusStopping means a cancellation has been requested but the handler
+	// has not yet observed it and reached a terminal status.
+	StatusStopping TaskStatus = "stopping"
+	// StatusSucceeded is a terminal status for a task that completed without error.
+	StatusSucceeded TaskStatus = "succeeded"
+	// StatusFailed is a terminal status for a task whose handler returned an error.
+	StatusFailed TaskStatus = "failed"
+	// StatusCancelled is a terminal status for a task that was stopped before
+	// it reached another terminal status.
+	StatusCancelled TaskStatus = "cancelled"
+)
+
+// Terminal reports whether s is a status a task can no longer transition out of.
+func (s TaskStatus) Terminal() bool {
+	switch s {
+	case StatusSucceeded, StatusFailed, StatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// TaskBase holds the fields common to every task, regardless of queue or type.
+type TaskBase struct {
+	Queue string          `json:"queue"`
+	Type  TaskType        `json:"type"`
+	Spec  json.RawMessage `json:"spec"`
+	// Priority biases claim order within a queue: a higher priority is
+	// claimed sooner, all else being equal. Defaults to 0.
+	Priority float64 `json:"priority,omitempty"`
+}
+
+// Task is a single unit of work as claimed by a worker.
+type Task struct {
+	TaskBase
+	ID          string     `json:"id"`
+	ExecutionID string     `json:"executionId"`
+	Status      TaskStatus `json:"status"`
+	RetryCount  int        `json:"retryCount"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// TaskScheduleRequest describes a recurring task to be registered with the
+// scheduler. Submitting the same request again (as determined by the
+// scheduler's dedupe rules) updates the existing schedule in place.
+type TaskScheduleRequest struct {
+	TaskBase
+	CronSchedule string `json:"cronSchedule"`
+}
+
+// TaskHandler executes the work described by a task's spec. Implementations
+// must respect ctx cancellation: once the scheduler stops a task, ctx is
+// cancelled and Handle is expected to return promptly.
+type TaskHandler interface {
+	Handle(ctx context.Context, task Task) error
+}